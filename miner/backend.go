@@ -0,0 +1,27 @@
+package miner
+
+import (
+	"srcd/common/common"
+	"srcd/core"
+	"srcd/core/blockchain"
+	"srcd/core/types"
+	"srcd/event"
+)
+
+// TxPool defines the methods needed from a transaction pool implementation to
+// support all the operations needed by the miner.
+type TxPool interface {
+	// Pending should return pending transactions.
+	// The slice should be modifiable by the caller.
+	Pending() (map[common.Address]types.Transactions, error)
+
+	// SubscribeNewTxsEvent should return an event subscription of
+	// NewTxsEvent and send events to the given channel.
+	SubscribeNewTxsEvent(chan<- core.NewTxsEvent) event.Subscription
+}
+
+// Backend wraps all methods required for mining.
+type Backend interface {
+	BlockChain() *blockchain.BlockChain
+	TxPool() TxPool
+}
@@ -0,0 +1,175 @@
+// Package miner implements SilkRoad block creation and mining.
+package miner
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"srcd/common/common"
+	"srcd/consensus"
+	"srcd/core/state"
+	"srcd/core/types"
+	"srcd/eth/downloader"
+	"srcd/event"
+	"srcd/log"
+	"srcd/params"
+)
+
+// Miner creates blocks and searches for proof-of-work values. It wraps a
+// worker, which hands sealing tasks to the consensus engine, and exposes the
+// control surface the node uses to start, stop and query mining.
+type Miner struct {
+	mux      *event.TypeMux
+	worker   *worker
+	coinbase common.Address
+	engine   consensus.Engine
+	exitCh   chan struct{}
+
+	canStart    int32 // whether mining is allowed to start (i.e. the node is not syncing)
+	shouldStart int32 // whether mining should (re)start once syncing finishes
+	mining      int32 // whether the miner is currently running
+}
+
+// New creates a new miner. Mining is not started automatically; call Start once
+// the caller is ready to seal blocks. noempty disables the speculative
+// empty-block sealing path entirely, which consensus engines that seal
+// instantly (e.g. instaseal) want since it only wastes work for them.
+// isLocalBlock reports whether a side block came from this node, so its uncle
+// is tracked as a local rather than a remote candidate.
+func New(server Backend, mux *event.TypeMux, engine consensus.Engine, noempty bool, isLocalBlock func(block *types.Block) bool) *Miner {
+	worker := newWorker(engine, server, mux, blockRecommitInterval)
+	worker.setNoempty(noempty)
+	worker.isLocalBlock = isLocalBlock
+
+	miner := &Miner{
+		mux:      mux,
+		engine:   engine,
+		exitCh:   make(chan struct{}),
+		worker:   worker,
+		canStart: 1,
+	}
+	go miner.update()
+	return miner
+}
+
+// update keeps track of the downloader events. This is a one-shot loop: once a
+// Done or Failed event has been broadcast the subscription is torn down and the
+// loop exits, so a malicious peer feeding a never-ending stream of sync-start
+// events cannot permanently wedge mining off.
+func (m *Miner) update() {
+	events := m.mux.Subscribe(downloader.StartEvent{}, downloader.DoneEvent{}, downloader.FailedEvent{})
+	defer events.Unsubscribe()
+
+	for {
+		select {
+		case ev := <-events.Chan():
+			if ev == nil {
+				return
+			}
+			switch ev.Data.(type) {
+			case downloader.StartEvent:
+				atomic.StoreInt32(&m.canStart, 0)
+				if m.Mining() {
+					m.Stop()
+					atomic.StoreInt32(&m.shouldStart, 1)
+					log.Info("Mining aborted due to sync")
+				}
+
+			case downloader.DoneEvent, downloader.FailedEvent:
+				shouldStart := atomic.LoadInt32(&m.shouldStart) == 1
+
+				atomic.StoreInt32(&m.canStart, 1)
+				atomic.StoreInt32(&m.shouldStart, 0)
+				if shouldStart {
+					m.Start(m.coinbase)
+				}
+				// Stop reacting to downloader events entirely once the sync is settled.
+				return
+			}
+
+		case <-m.exitCh:
+			return
+		}
+	}
+}
+
+// Start sets the etherbase and, unless the node is currently syncing, starts
+// the worker sealing blocks. If syncing is in progress, the request is
+// remembered and honoured automatically once update() observes sync completion.
+func (m *Miner) Start(coinbase common.Address) {
+	atomic.StoreInt32(&m.shouldStart, 1)
+	m.SetEtherbase(coinbase)
+
+	if atomic.LoadInt32(&m.canStart) == 0 {
+		log.Info("Network syncing, will start miner afterwards")
+		return
+	}
+	atomic.StoreInt32(&m.mining, 1)
+
+	m.worker.start()
+}
+
+// Stop halts the worker. It can be restarted by calling Start again.
+func (m *Miner) Stop() {
+	m.worker.stop()
+	atomic.StoreInt32(&m.mining, 0)
+	atomic.StoreInt32(&m.shouldStart, 0)
+}
+
+// Close terminates the miner and all of its background goroutines. A closed
+// Miner cannot be restarted.
+func (m *Miner) Close() {
+	m.worker.close()
+	close(m.exitCh)
+}
+
+// Mining returns whether the miner is currently sealing blocks.
+func (m *Miner) Mining() bool {
+	return atomic.LoadInt32(&m.mining) > 0
+}
+
+// HashRate returns the current hashrate of the mining engine, if it supports
+// proof-of-work hashing.
+func (m *Miner) HashRate() uint64 {
+	if pow, ok := m.engine.(consensus.PoW); ok {
+		return pow.Hashrate()
+	}
+	return 0
+}
+
+// SetExtra sets the content used to initialize the block extra field.
+func (m *Miner) SetExtra(extra []byte) error {
+	if uint64(len(extra)) > params.MaximumExtraDataSize {
+		return fmt.Errorf("extra exceeds max length, %d > %v", len(extra), params.MaximumExtraDataSize)
+	}
+	m.worker.setExtra(extra)
+	return nil
+}
+
+// SetRecommitInterval sets the time interval used to resubmit mining work.
+func (m *Miner) SetRecommitInterval(interval time.Duration) {
+	m.worker.setRecommitInterval(interval)
+}
+
+// SetRecommitAdjustRatio sets the impact a single interval adjustment has on
+// the resubmitting interval, in the range [0, 1].
+func (m *Miner) SetRecommitAdjustRatio(ratio float64) {
+	m.worker.setRecommitAdjustRatio(ratio)
+}
+
+// Pending returns the currently pending block and its associated state.
+func (m *Miner) Pending() (*types.Block, *state.StateDB) {
+	return m.worker.pending()
+}
+
+// PendingBlock returns the currently pending block.
+func (m *Miner) PendingBlock() *types.Block {
+	return m.worker.pendingBlock()
+}
+
+// SetEtherbase sets the etherbase used to initialize the block coinbase field.
+func (m *Miner) SetEtherbase(addr common.Address) {
+	m.coinbase = addr
+	m.worker.setCoinbase(addr)
+}
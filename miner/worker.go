@@ -2,18 +2,24 @@ package miner
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"math/big"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	mapset "github.com/deckarep/golang-set"
+
 	"srcd/common/common"
 	"srcd/consensus"
 	"srcd/core"
 	"srcd/core/blockchain"
+	"srcd/core/state"
 	"srcd/core/types"
+	"srcd/event"
 	"srcd/log"
+	"srcd/params"
 )
 
 const (
@@ -27,24 +33,66 @@ const (
 	// chainHeadChanSize is the size of channel listening to ChainHeadEvent.
 	chainHeadChanSize = 10
 
+	// chainSideChanSize is the size of channel listening to ChainSideEvent.
+	chainSideChanSize = 10
+
+	// resubmitAdjustChanSize is the size of resubmitting interval adjustment channel.
+	resubmitAdjustChanSize = 10
+
 	// miningLogAtDepth is the number of confirmations before logging successful mining.
 	miningLogAtDepth = 5
 
-	// blockRecommitInterval is the time interval to recreate the mining block with
+	// blockRecommitInterval is the default time interval to recreate the mining block with
 	// any newly arrived transactions.
 	blockRecommitInterval = 3 * time.Second
+
+	// minRecommitInterval is the minimal time interval to recreate the mining block with
+	// any newly arrived transactions.
+	minRecommitInterval = 1 * time.Second
+
+	// maxRecommitInterval is the maximum time interval to recreate the mining block with
+	// any newly arrived transactions.
+	maxRecommitInterval = 15 * time.Second
+
+	// intervalAdjustRatio is the default impact a single interval adjustment has on the
+	// sealing work resubmitting interval.
+	intervalAdjustRatio = 0.1
+
+	// intervalAdjustBias is applied during the new resubmit interval calculation in favor of
+	// increasing upper limit or decreasing lower limit so that the limit can be reachable.
+	intervalAdjustBias = 200 * 1000.0 * 1000.0
+
+	// staleThreshold sets how long uncle candidates are kept in localUncles/remoteUncles:
+	// a candidate is pruned as stale once it lags the head by more than twice this many blocks.
+	staleThreshold = 7
 )
 
+// intervalAdjust represents a resubmitting interval adjustment.
+type intervalAdjust struct {
+	ratio float64
+	inc   bool
+}
+
 // environment is the worker's current environment and holds all of the current state information.
 type environment struct {
-	signer   types.Signer
-	tcount   int            // tx count in cycle
+	signer types.Signer
+
+	state     *state.StateDB // apply state changes here
+	ancestors mapset.Set     // ancestor set (used for checking uncle parent validity)
+	family    mapset.Set     // family set (used for checking uncle invalidity)
+	uncles    mapset.Set     // uncle set
+	tcount    int            // tx count in cycle
+	gasPool   *core.GasPool  // available gas used to pack transactions
+
 	header   *types.Header
 	txs      []*types.Transaction
+	receipts []*types.Receipt
 }
 
 // task contains all information for consensus engine sealing and result submitting.
 type task struct {
+	receipts  []*types.Receipt
+	state     *state.StateDB
 	block     *types.Block
 	createdAt time.Time
 }
@@ -70,58 +118,85 @@ type worker struct {
 	// Subscriptions
 	mux           *event.TypeMux
 	txsCh         chan core.NewTxsEvent
-	// txsSub        event.Subscription
+	txsSub        event.Subscription
 	chainHeadCh   chan core.ChainHeadEvent
-	// chainHeadSub event.Subscription
+	chainHeadSub  event.Subscription
+	chainSideCh   chan core.ChainSideEvent
+	chainSideSub  event.Subscription
 
 	// Channels
-	newWorkCh     chan *newWorkReq
-	taskCh        chan *task
-	resultCh      chan *task
-	startCh       chan struct{}
-	exitCh        chan struct{}
+	newWorkCh          chan *newWorkReq
+	taskCh             chan *task
+	resultCh           chan *task
+	startCh            chan struct{}
+	exitCh             chan struct{}
+	resubmitIntervalCh chan time.Duration
+	resubmitAdjustCh   chan *intervalAdjust
+	resubmitRatioCh    chan float64
 
 	current       *environment        // An environment for current running cycle.
 	unconfirmed   *unconfirmedBlocks  // A set of locally mined blocks pending canonicalness confirmations.
 
+	localUncles   map[common.Hash]*types.Block   // A set of side blocks generated locally as the possible uncle blocks.
+	remoteUncles  map[common.Hash]*types.Block   // A set of side blocks as the possible uncle blocks.
+	isLocalBlock  func(block *types.Block) bool  // Function used to determine whether the specified block is mined by local miner.
+
 	mu            sync.RWMutex        // The lock used to protect the coinbase and extra fields
 	coinbase      common.Address
 	extra         []byte
 
 	snapshotMu    sync.RWMutex        // The lock used to protect the block snapshot and state snapshot
 	snapshotBlock *types.Block
+	snapshotState *state.StateDB
 
 	// atomic status counters
 	running       int32               // The indicator whether the consensus engine is running or not.
+	noempty       int32               // The indicator whether the speculative empty-block sealing path is disabled.
 
 	// Test hooks
 	newTaskHook   func(*task)         // Method to call upon receiving a new sealing task
 	skipSealHook  func(*task) bool    // Method to decide whether skipping the sealing.
 	fullTaskHook  func()              // Method to call before pushing the full sealing task
+	resubmitHook  func(time.Duration, time.Duration) // Method to call upon updating resubmitting interval.
 }
 
-func newWorker(engine consensus.Engine, server Backend, mux *event.TypeMux) *worker {
+func newWorker(engine consensus.Engine, server Backend, mux *event.TypeMux, recommit time.Duration) *worker {
+	if recommit < minRecommitInterval {
+		log.Warn("Sanitizing miner recommit interval", "provided", recommit, "updated", minRecommitInterval)
+		recommit = minRecommitInterval
+	}
 	worker := &worker{
-		engine:         engine,
-		server:         server,
-		mux:            mux,
-		chain:          server.BlockChain(),
-		unconfirmed:    newUnconfirmedBlocks(server.BlockChain(), miningLogAtDepth),
-		txsCh:          make(chan core.NewTxsEvent, txChanSize),
-		chainHeadCh:    make(chan core.ChainHeadEvent, chainHeadChanSize),
-		newWorkCh:      make(chan *newWorkReq),
-		taskCh:         make(chan *task),
-		resultCh:       make(chan *task, resultQueueSize),
-		exitCh:         make(chan struct{}),
-		startCh:        make(chan struct{}, 1),
-	}
-	// // Subscribe NewTxsEvent for tx pool
-	// worker.txsSub = server.TxPool().SubscribeNewTxsEvent(worker.txsCh)
-	// // Subscribe events for blockchain
-	// worker.chainHeadSub = server.BlockChain().SubscribeChainHeadEvent(worker.chainHeadCh)
+		engine:             engine,
+		server:             server,
+		mux:                mux,
+		chain:              server.BlockChain(),
+		unconfirmed:        newUnconfirmedBlocks(server.BlockChain(), miningLogAtDepth),
+		localUncles:        make(map[common.Hash]*types.Block),
+		remoteUncles:       make(map[common.Hash]*types.Block),
+		txsCh:              make(chan core.NewTxsEvent, txChanSize),
+		chainHeadCh:        make(chan core.ChainHeadEvent, chainHeadChanSize),
+		chainSideCh:        make(chan core.ChainSideEvent, chainSideChanSize),
+		newWorkCh:          make(chan *newWorkReq),
+		taskCh:             make(chan *task),
+		resultCh:           make(chan *task, resultQueueSize),
+		exitCh:             make(chan struct{}),
+		startCh:            make(chan struct{}, 1),
+		resubmitIntervalCh: make(chan time.Duration),
+		resubmitAdjustCh:   make(chan *intervalAdjust, resubmitAdjustChanSize),
+		resubmitRatioCh:    make(chan float64),
+	}
+	// Subscribe NewTxsEvent for tx pool
+	worker.txsSub = server.TxPool().SubscribeNewTxsEvent(worker.txsCh)
+	// Subscribe events for blockchain
+	worker.chainHeadSub = server.BlockChain().SubscribeChainHeadEvent(worker.chainHeadCh)
+	// SubscribeChainSideEvent is assumed to exist on *blockchain.BlockChain
+	// alongside SubscribeChainHeadEvent; that type lives outside this
+	// miner-only chunk, so if it hasn't been added yet this call (and the
+	// uncle-tracking path it feeds in mainLoop) won't build until it is.
+	worker.chainSideSub = server.BlockChain().SubscribeChainSideEvent(worker.chainSideCh)
 
 	go worker.mainLoop()
-	go worker.newWorkLoop()
+	go worker.newWorkLoop(recommit)
 	go worker.resultLoop()
 	go worker.taskLoop()
 
@@ -145,8 +220,37 @@ func (w *worker) setExtra(extra []byte) {
 	w.extra = extra
 }
 
-// pending returns pending block.
-func (w *worker) pending() *types.Block {
+// setRecommitInterval updates the interval for miner sealing work resubmitting.
+func (w *worker) setRecommitInterval(interval time.Duration) {
+	w.resubmitIntervalCh <- interval
+}
+
+// setRecommitAdjustRatio updates the impact a single interval adjustment has on
+// the resubmitting interval, bounding it to a sane [0, 1] range. The update is
+// applied by newWorkLoop itself, so it is safe to call from any goroutine.
+func (w *worker) setRecommitAdjustRatio(ratio float64) {
+	if ratio < 0 {
+		ratio = 0
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	w.resubmitRatioCh <- ratio
+}
+
+// pending returns the pending block and its associated state.
+func (w *worker) pending() (*types.Block, *state.StateDB) {
+	// return a snapshot to avoid contention on currentMu mutex
+	w.snapshotMu.RLock()
+	defer w.snapshotMu.RUnlock()
+	if w.snapshotState == nil {
+		return nil, nil
+	}
+	return w.snapshotBlock, w.snapshotState.Copy()
+}
+
+// pendingBlock returns pending block.
+func (w *worker) pendingBlock() *types.Block {
 	// return a snapshot to avoid contention on currentMu mutex
 	w.snapshotMu.RLock()
 	defer w.snapshotMu.RUnlock()
@@ -169,6 +273,17 @@ func (w *worker) isRunning() bool {
 	return atomic.LoadInt32(&w.running) == 1
 }
 
+// setNoempty toggles whether the worker skips the speculative empty-block sealing
+// path entirely. Consensus engines that seal instantly (e.g. instaseal) gain
+// nothing from racing an empty block against the full one, so they disable it.
+func (w *worker) setNoempty(noempty bool) {
+	if noempty {
+		atomic.StoreInt32(&w.noempty, 1)
+	} else {
+		atomic.StoreInt32(&w.noempty, 0)
+	}
+}
+
 // close terminates all background threads maintained by the worker and cleans up buffered channels.
 // Note the worker does not support being closed multiple times.
 func (w *worker) close() {
@@ -184,36 +299,90 @@ func (w *worker) close() {
 }
 
 // newWorkLoop is a standalone goroutine to submit new mining work upon received events.
-func (w *worker) newWorkLoop() {
-	var interrupt *int32
+func (w *worker) newWorkLoop(recommit time.Duration) {
+	var (
+		interrupt      *int32
+		minRecommit    = recommit           // minimal resubmit interval specified by user.
+		recommitAdjust = intervalAdjustRatio // impact a single interval adjustment has on recommit, owned by this loop.
+	)
 
 	timer := time.NewTimer(0)
 	<-timer.C // discard the initial tick
 
-	// recommit aborts in-flight transaction execution with given signal and resubmits a new one.
-	recommit := func(noempty bool, s int32) {
+	// commit aborts in-flight transaction execution with given signal and resubmits a new one.
+	commit := func(noempty bool, s int32) {
 		if interrupt != nil {
 			atomic.StoreInt32(interrupt, s)
 		}
 		interrupt = new(int32)
 		w.newWorkCh <- &newWorkReq{interrupt: interrupt, noempty: noempty}
-		timer.Reset(blockRecommitInterval)
+		timer.Reset(recommit)
+	}
+	// recalcRecommit recalculates the resubmitting interval upon feedback.
+	recalcRecommit := func(target float64, inc bool) {
+		var next float64
+		prev := float64(recommit.Nanoseconds())
+		if inc {
+			next = prev*(1-recommitAdjust) + recommitAdjust*(target+intervalAdjustBias)
+			// Recap if interval is larger than the maximum time interval
+			if maxInt := float64(maxRecommitInterval.Nanoseconds()); next > maxInt {
+				next = maxInt
+			}
+		} else {
+			next = prev*(1-recommitAdjust) + recommitAdjust*(target-intervalAdjustBias)
+			// Recap if interval is less than the user specified minimum
+			if minInt := float64(minRecommit.Nanoseconds()); next < minInt {
+				next = minInt
+			}
+		}
+		recommit = time.Duration(int64(next))
 	}
 
 	for {
 		select {
 		case <-w.startCh:
-			recommit(false, commitInterruptNewHead)
+			commit(false, commitInterruptNewHead)
 
 		case <-w.chainHeadCh:
-			recommit(false, commitInterruptNewHead)
+			commit(false, commitInterruptNewHead)
 
 		case <-timer.C:
 			// If mining is running resubmit a new work cycle periodically to pull in
 			// higher priced transactions. Disable this overhead for pending blocks.
 			if w.isRunning() {
-				recommit(true, commitInterruptResubmit)
+				commit(true, commitInterruptResubmit)
+			}
+
+		case interval := <-w.resubmitIntervalCh:
+			// Adjust resubmit interval explicitly by user.
+			if interval < minRecommitInterval {
+				log.Warn("Sanitizing miner recommit interval", "provided", interval, "updated", minRecommitInterval)
+				interval = minRecommitInterval
 			}
+			log.Info("Miner recommit interval update", "from", minRecommit, "to", interval)
+			minRecommit, recommit = interval, interval
+
+			if w.resubmitHook != nil {
+				w.resubmitHook(minRecommit, recommit)
+			}
+
+		case adjust := <-w.resubmitAdjustCh:
+			before := recommit
+			// Adjust resubmit interval by feedback.
+			if adjust.inc {
+				recalcRecommit(float64(recommit.Nanoseconds())/adjust.ratio, true)
+			} else {
+				recalcRecommit(minRecommit.Seconds()*1000*1000*1000, false)
+			}
+			log.Trace("Miner recommit interval adjusted", "from", before, "to", recommit, "inc", adjust.inc)
+
+			if w.resubmitHook != nil {
+				w.resubmitHook(minRecommit, recommit)
+			}
+
+		case ratio := <-w.resubmitRatioCh:
+			log.Info("Miner recommit adjust ratio update", "from", recommitAdjust, "to", ratio)
+			recommitAdjust = ratio
 
 		case <-w.exitCh:
 			return
@@ -223,8 +392,9 @@ func (w *worker) newWorkLoop() {
 
 // mainLoop is a standalone goroutine to regenerate the sealing task based on the received event.
 func (w *worker) mainLoop() {
-	// defer w.txsSub.Unsubscribe()
-	// defer w.chainHeadSub.Unsubscribe()
+	defer w.txsSub.Unsubscribe()
+	defer w.chainHeadSub.Unsubscribe()
+	defer w.chainSideSub.Unsubscribe()
 
 	for {
 		select {
@@ -252,13 +422,47 @@ func (w *worker) mainLoop() {
 				w.updateSnapshot()
 			}
 
+		case ev := <-w.chainSideCh:
+			// Keep track of side blocks as the possible uncles of the block currently
+			// being assembled, so commitNewWork can select uncle candidates from them.
+			// The selected uncles are not put into the sealed block (see commit).
+			if _, exist := w.localUncles[ev.Block.Hash()]; exist {
+				continue
+			}
+			if _, exist := w.remoteUncles[ev.Block.Hash()]; exist {
+				continue
+			}
+			// Collect the sidechain block as a possible uncle. Add the block generated
+			// by ourselves as an uncle candidate too.
+			if w.isLocalBlock != nil && w.isLocalBlock(ev.Block) {
+				w.localUncles[ev.Block.Hash()] = ev.Block
+			} else {
+				w.remoteUncles[ev.Block.Hash()] = ev.Block
+			}
+			// Clear out stale uncle candidates whose height lags too far behind the
+			// current head so the maps don't grow without bound.
+			if current := w.chain.CurrentBlock(); current != nil {
+				for hash, uncle := range w.localUncles {
+					if uncle.NumberU64()+staleThreshold*2 < current.NumberU64() {
+						delete(w.localUncles, hash)
+					}
+				}
+				for hash, uncle := range w.remoteUncles {
+					if uncle.NumberU64()+staleThreshold*2 < current.NumberU64() {
+						delete(w.remoteUncles, hash)
+					}
+				}
+			}
+
 		// System stopped
 		case <-w.exitCh:
 			return
-		// case <-w.txsSub.Err():
-			// return
-		// case <-w.chainHeadSub.Err():
-			// return
+		case <-w.txsSub.Err():
+			return
+		case <-w.chainHeadSub.Err():
+			return
+		case <-w.chainSideSub.Err():
+			return
 		}
 	}
 }
@@ -291,7 +495,9 @@ func (w *worker) seal(t *task, stop <-chan struct{}) {
 }
 
 // taskLoop is a standalone goroutine to fetch sealing task from the generator and
-// push them to consensus engine.
+// push them to consensus engine. At most one seal is ever in flight; submitting a
+// new task (e.g. the full block following its speculative empty counterpart for
+// the same parent) cancels whatever is still being sealed via its stopCh.
 func (w *worker) taskLoop() {
 	var stopCh chan struct{}
 
@@ -318,9 +524,25 @@ func (w *worker) taskLoop() {
 	}
 }
 
+// sealedCacheLimit bounds how many recent parent hashes resultLoop remembers in
+// order to dedupe a speculative empty block against the full block that raced it.
+const sealedCacheLimit = 16
+
+// sealedEntry records the best result resultLoop has written so far for a
+// given parent, so a later result for the same parent can be judged against
+// it instead of being discarded purely on arrival order.
+type sealedEntry struct {
+	hash  common.Hash
+	empty bool
+}
+
 // resultLoop is a standalone goroutine to handle sealing result submitting
 // and flush relative data to the database.
 func (w *worker) resultLoop() {
+	var (
+		sealed      = make(map[common.Hash]sealedEntry, sealedCacheLimit) // parent hash -> last written result
+		sealedOrder []common.Hash
+	)
 	for {
 		select {
 		case result := <-w.resultCh:
@@ -328,6 +550,28 @@ func (w *worker) resultLoop() {
 				continue
 			}
 			block := result.block
+			header := block.Header()
+			empty := len(block.Transactions()) == 0
+
+			// taskLoop cancels an in-flight empty seal as soon as the full task for
+			// the same parent arrives, but the cancellation can lose a narrow race
+			// against an engine that seals the empty block near-instantly, leaving
+			// both results in resultCh. Prefer the full block over the empty one
+			// for a given parent regardless of arrival order, and only discard a
+			// result outright once a full block has already won that parent.
+			if prev, ok := sealed[header.ParentHash]; ok {
+				if prev.hash == block.Hash() || !prev.empty {
+					log.Trace("Discarding competing sealing result", "number", block.Number(), "hash", block.Hash())
+					continue
+				}
+			} else {
+				sealedOrder = append(sealedOrder, header.ParentHash)
+				if len(sealedOrder) > sealedCacheLimit {
+					delete(sealed, sealedOrder[0])
+					sealedOrder = sealedOrder[1:]
+				}
+			}
+			sealed[header.ParentHash] = sealedEntry{hash: block.Hash(), empty: empty}
 
 			// Commit block to database.
 			stat, err := w.chain.WriteBlockWithState(block, result.receipts, result.state)
@@ -335,20 +579,20 @@ func (w *worker) resultLoop() {
 				log.Error("Failed writing block to chain", "err", err)
 				continue
 			}
-			// // Broadcast the block and announce chain insertion event
-			// w.mux.Post(core.NewMinedBlockEvent{Block: block})
-			// var (
-				// events []interface{}
-				// logs   = result.state.Logs()
-			// )
-			// switch stat {
-			// case core.CanonStatTy:
-				// events = append(events, core.ChainEvent{Block: block, Hash: block.Hash(), Logs: logs})
-				// events = append(events, core.ChainHeadEvent{Block: block})
-			// case core.SideStatTy:
-				// events = append(events, core.ChainSideEvent{Block: block})
-			// }
-			// w.chain.PostChainEvents(events, logs)
+			// Broadcast the block and announce chain insertion event
+			w.mux.Post(core.NewMinedBlockEvent{Block: block})
+			var (
+				events []interface{}
+				logs   = result.state.Logs()
+			)
+			switch stat {
+			case core.CanonStatTy:
+				events = append(events, core.ChainEvent{Block: block, Hash: block.Hash(), Logs: logs})
+				events = append(events, core.ChainHeadEvent{Block: block})
+			case core.SideStatTy:
+				events = append(events, core.ChainSideEvent{Block: block})
+			}
+			w.chain.PostChainEvents(events, logs)
 
 			// Insert the block into the set of pending ones to resultLoop for confirmations
 			w.unconfirmed.Insert(block.NumberU64(), block.Hash())
@@ -361,16 +605,24 @@ func (w *worker) resultLoop() {
 
 // makeCurrent creates a new environment for the current cycle.
 func (w *worker) makeCurrent(parent *types.Block, header *types.Header) error {
+	state, err := w.chain.StateAt(parent.Root())
+	if err != nil {
+		return err
+	}
 	env := &environment{
 		signer:    types.NewMasterSigner(),
+		state:     state,
+		ancestors: mapset.NewSet(),
+		family:    mapset.NewSet(),
+		uncles:    mapset.NewSet(),
 		header:    header,
 	}
 
-	// // when 08 is processed ancestors contain 07 (quick block)
-	// for _, ancestor := range w.chain.GetBlocksFromHash(parent.Hash(), 7) {
-		// env.family.Add(ancestor.Hash())
-		// env.ancestors.Add(ancestor.Hash())
-	// }
+	// when 08 is processed ancestors contain 07 (quick block)
+	for _, ancestor := range w.chain.GetBlocksFromHash(parent.Hash(), 7) {
+		env.family.Add(ancestor.Hash())
+		env.ancestors.Add(ancestor.Hash())
+	}
 
 	// Keep track of transactions which return errors so they can be removed
 	env.tcount = 0
@@ -388,16 +640,19 @@ func (w *worker) updateSnapshot() {
 		w.current.header,
 		w.current.txs,
 	)
+	w.snapshotState = w.current.state.Copy()
 }
 
 func (w *worker) commitTransaction(tx *types.Transaction, coinbase common.Address) error {
-	// receipt, _, err := core.ApplyTransaction(w.config, w.chain, &coinbase, w.current.gasPool, w.current.state, w.current.header, tx, &w.current.header.GasUsed)
-	// if err != nil {
-		// w.current.state.RevertToSnapshot(snap)
-		// return err
-	// }
+	snap := w.current.state.Snapshot()
 
+	receipt, _, err := core.ApplyTransaction(w.chain.Config(), w.chain, &coinbase, w.current.gasPool, w.current.state, w.current.header, tx, &w.current.header.GasUsed)
+	if err != nil {
+		w.current.state.RevertToSnapshot(snap)
+		return err
+	}
 	w.current.txs = append(w.current.txs, tx)
+	w.current.receipts = append(w.current.receipts, receipt)
 
 	return nil
 }
@@ -408,6 +663,10 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 		return true
 	}
 
+	if w.current.gasPool == nil {
+		w.current.gasPool = new(core.GasPool).AddGas(w.current.header.GasLimit)
+	}
+
 	for {
 		// In the following three cases, we will interrupt the execution of the transaction.
 		// (1) new head block event arrival, the interrupt signal is 1
@@ -415,12 +674,26 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 		// (3) worker recreate the mining block with any newly arrived transactions, the interrupt signal is 2.
 		// For the first two cases, the semi-finished work will be discarded.
 		// For the third case, the semi-finished work will be submitted to the consensus engine.
-		// TODO(rjl493456442) give feedback to newWorkLoop to adjust resubmit interval if it is too short.
 		if interrupt != nil && atomic.LoadInt32(interrupt) != commitInterruptNone {
+			// Notify resubmit loop to increase resubmitting interval due to too frequent commits.
+			if atomic.LoadInt32(interrupt) == commitInterruptResubmit {
+				ratio := float64(w.current.header.GasLimit-w.current.gasPool.Gas()) / float64(w.current.header.GasLimit)
+				if ratio < 0.1 {
+					ratio = 0.1
+				}
+				w.resubmitAdjustCh <- &intervalAdjust{
+					ratio: ratio,
+					inc:   true,
+				}
+			}
 			return atomic.LoadInt32(interrupt) == commitInterruptNewHead
 		}
 
-		// check tx fees ...
+		// If we don't have enough gas for any further transactions then we're done
+		if w.current.gasPool.Gas() < params.TxGas {
+			log.Trace("Not enough gas for further transactions", "have", w.current.gasPool, "want", params.TxGas)
+			break
+		}
 
 		// Retrieve the next transaction and abort if all done
 		tx := txs.Peek()
@@ -438,8 +711,26 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 			continue
 		}
 
+		// Start executing the transaction
+		w.current.state.Prepare(tx.Hash(), common.Hash{}, w.current.tcount)
+
 		err := w.commitTransaction(tx, coinbase)
 		switch err {
+		case core.ErrGasLimitReached:
+			// Pop the current out-of-gas transaction without shifting in the next from the account
+			log.Trace("Gas limit exceeded for current block", "sender", from)
+			txs.Pop()
+
+		case core.ErrNonceTooLow:
+			// New head notification data race between the transaction pool and miner, shift
+			log.Trace("Skipping transaction with low nonce", "sender", from, "nonce", tx.Nonce())
+			txs.Shift()
+
+		case core.ErrNonceTooHigh:
+			// Reorg notification data race between the transaction pool and miner, skip account =
+			log.Trace("Skipping account with high nonce", "sender", from, "nonce", tx.Nonce())
+			txs.Pop()
+
 		case nil:
 			// Everything ok, shift in the next transaction from the same account
 			w.current.tcount++
@@ -502,10 +793,13 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool) {
 		return
 	}
 
-	if !noempty {
+	// Accumulate the uncles for the sidechain blocks we've collected.
+	uncles := w.collectUncles()
+
+	if !noempty && atomic.LoadInt32(&w.noempty) == 0 {
 		// Create an empty block based on temporary copied state for sealing in advance without waiting block
 		// execution finished.
-		w.commit(nil, false, tstart)
+		w.commit(uncles, nil, false, tstart)
 	}
 
 	// Fill the block with all available pending transactions.
@@ -523,12 +817,67 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool) {
 	if w.commitTransactions(txs, w.coinbase, interrupt) {
 		return
 	}
+	// commitTransactions also returns false when it was interrupted by a resubmit
+	// (having already sent its own grow signal on interrupt), so only shrink the
+	// interval here when the block genuinely filled without any interrupt firing.
+	if interrupt == nil || atomic.LoadInt32(interrupt) == commitInterruptNone {
+		select {
+		case w.resubmitAdjustCh <- &intervalAdjust{inc: false}:
+		default:
+		}
+	}
+
+	w.commit(uncles, w.fullTaskHook, true, tstart)
+}
 
-	w.commit(w.fullTaskHook, true, tstart)
+// collectUncles gathers up to two valid uncles for the block currently being
+// assembled from the locally-mined and remotely-observed side block candidates.
+func (w *worker) collectUncles() []*types.Header {
+	var uncles []*types.Header
+	for _, blocks := range []map[common.Hash]*types.Block{w.localUncles, w.remoteUncles} {
+		for hash, uncle := range blocks {
+			if len(uncles) == 2 {
+				break
+			}
+			if err := w.commitUncle(w.current, uncle.Header()); err != nil {
+				log.Trace("Possible uncle rejected", "hash", hash, "reason", err)
+			} else {
+				log.Debug("Committing new uncle to block", "hash", hash)
+				uncles = append(uncles, uncle.Header())
+			}
+		}
+	}
+	return uncles
+}
+
+// commitUncle adds the given block to uncle block set, returning an error if
+// the uncle is invalid.
+func (w *worker) commitUncle(env *environment, uncle *types.Header) error {
+	hash := uncle.Hash()
+	if env.uncles.Contains(hash) {
+		return errors.New("uncle not unique")
+	}
+	if !env.ancestors.Contains(uncle.ParentHash) {
+		return errors.New("uncle's parent unknown")
+	}
+	if env.family.Contains(hash) {
+		return errors.New("uncle already included")
+	}
+	env.uncles.Add(uncle.Hash())
+	return nil
 }
 
 // commit assembles the final block and commits new work if consensus engine is running.
-func (w *worker) commit(interval func(), update bool, start time.Time) error {
+//
+// uncles is accepted for the engine to fold into the finalized block once
+// consensus.Engine grows an uncle-aware Finalize; that interface lives outside
+// this chunk and today only exposes the 2-arg (header, txs) form, so uncles
+// is not yet forwarded into the sealed block.
+func (w *worker) commit(uncles []*types.Header, interval func(), update bool, start time.Time) error {
+	// Deep copy receipts here to avoid interaction between different tasks.
+	receipts := make([]*types.Receipt, len(w.current.receipts))
+	copy(receipts, w.current.receipts)
+	s := w.current.state.Copy()
 	block, err := w.engine.Finalize(w.current.header, w.current.txs)
 	if err != nil {
 		return err
@@ -539,8 +888,10 @@ func (w *worker) commit(interval func(), update bool, start time.Time) error {
 			interval()
 		}
 		select {
-		case w.taskCh <- &task{block: block, createdAt: time.Now()}:
+		case w.taskCh <- &task{receipts: receipts, state: s, block: block, createdAt: time.Now()}:
 			w.unconfirmed.Shift(block.NumberU64() - 1)
+			log.Info("Commit new mining work", "number", block.Number(), "txs", w.current.tcount,
+				"elapsed", common.PrettyDuration(time.Since(start)))
 
 		case <-w.exitCh:
 			log.Info("Worker has exited")